@@ -0,0 +1,157 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotImplemented is returned by backends that do not support an
+// optional capability, e.g. locking on memcache/mock.
+var ErrNotImplemented = errors.New("backend: not implemented")
+
+// Store is implemented by every supported key/value backend (etcd,
+// consul, zookeeper, memcache, mock, ...).
+type Store interface {
+	Get(key string) ([]byte, error)
+	List(key string) (KVPairs, error)
+	Set(key string, value []byte) error
+	Watch(key string, stop chan bool) <-chan *Response
+	Delete(key string) error
+	SetLogger(l Logger)
+
+	// NewLock returns a Locker that coordinates mutual exclusion /
+	// leader election on key. Backends without native locking support
+	// return ErrNotImplemented.
+	NewLock(key string, options *LockOptions) (Locker, error)
+
+	// AtomicPut writes value to key only if the key's current
+	// LastIndex equals previous.LastIndex (or key does not yet exist,
+	// when previous is nil), returning the resulting KVPair with its
+	// new LastIndex. The comparison is index-based, not content-based,
+	// so it works for values that are re-encrypted (and therefore
+	// change on every write) as well as plain ones.
+	AtomicPut(key string, value []byte, previous *KVPair, options *WriteOptions) (*KVPair, error)
+	// AtomicDelete removes key only if its current LastIndex matches
+	// previous.LastIndex.
+	AtomicDelete(key string, previous *KVPair) error
+}
+
+// WriteOptions configure a conditional write via Store.AtomicPut.
+type WriteOptions struct {
+	// TTL is not applied by any of the backends in this module; their
+	// AtomicPut implementations ignore it.
+	TTL time.Duration
+}
+
+// LockOptions configure a Locker created via Store.NewLock.
+type LockOptions struct {
+	// TTL is the session/lease lifetime backends that support one
+	// (etcd, consul) use to detect a dead holder.
+	TTL time.Duration
+	// Value is stored alongside the lock so other clients can see who
+	// (or what) holds it. Only the consul backend stores it; etcd and
+	// zookeeper ignore it.
+	Value []byte
+}
+
+// Locker coordinates mutual exclusion or leader election on a key.
+type Locker interface {
+	// Lock blocks until the lock is acquired or stop is closed, in
+	// which case it returns early with an error. The returned channel
+	// is closed when the underlying session is lost, so the holder
+	// knows to step down.
+	Lock(stop chan struct{}) (<-chan struct{}, error)
+	// Unlock releases a held lock.
+	Unlock() error
+}
+
+// KVPair is a single key/value entry returned by a Store.
+type KVPair struct {
+	Key   string
+	Value []byte
+	// LastIndex is a backend-specific version/revision stamp (etcd's
+	// modified index, zookeeper's stat.Version, consul's ModifyIndex,
+	// ...) used for conditional writes. The memcache backend does not
+	// populate it and leaves it at 0.
+	LastIndex uint64
+}
+
+// KVPairs is a list of KVPair entries returned by List.
+type KVPairs []*KVPair
+
+// Response is emitted on the channel returned by Store.Watch.
+type Response struct {
+	Value []byte
+	Error error
+}
+
+// Logger is satisfied by *log.Logger and used to surface backend
+// connection issues (reconnects, session expiry, etc).
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Config carries the connection options shared by every backend, so
+// that individual backend constructors no longer need their own
+// bespoke signature for TLS, auth, timeouts, etc. TLS material is
+// taken as PEM bytes rather than file paths, so callers are never
+// required to write key material to disk.
+type Config struct {
+	Cert               []byte
+	Key                []byte
+	CACert             []byte
+	InsecureSkipVerify bool
+
+	Username string
+	Password string
+	Token    string
+
+	ConnectTimeout time.Duration
+
+	// Bucket names the backend-specific grouping of keys, where one
+	// applies (boltdb's bucket).
+	Bucket string
+}
+
+// Factory builds a Store for a registered backend name.
+type Factory func(machines []string, options *Config) (Store, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[string]Factory)
+)
+
+// Register plugs a backend factory into the registry under name.
+// Backend packages call this from an init(), so that callers can
+// create a Store by name via NewStore without importing the backend
+// package directly, and third parties can add their own backends
+// without forking this module.
+func Register(name string, factory Factory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[name] = factory
+}
+
+// NewStore creates a Store for the named, registered backend.
+func NewStore(name string, machines []string, options *Config) (Store, error) {
+	backendsMu.RLock()
+	factory, ok := backends[name]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("backend: no store registered for %q", name)
+	}
+	return factory(machines, options)
+}
+
+// KeyNotFound reports whether err represents a missing key. Backends
+// format their own "not found" errors, so this matches on the common
+// wording rather than a single concrete type.
+func KeyNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	return strings.Contains(err.Error(), "was not found")
+}
@@ -0,0 +1,249 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ltick/crypt/backend"
+	bolt "go.etcd.io/bbolt"
+)
+
+const defaultBucket = "crypt"
+
+const watchPollInterval = time.Second
+
+// indexBucket returns the name of the sibling bucket that tracks each
+// key's LastIndex, so AtomicPut/AtomicDelete can condition on it
+// without re-deriving a version from the value itself.
+func indexBucket(bucket string) string {
+	return bucket + ".idx"
+}
+
+func getIndex(b *bolt.Bucket, key string) uint64 {
+	v := b.Get([]byte(key))
+	if v == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+func putIndex(b *bolt.Bucket, key string, index uint64) error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, index)
+	return b.Put([]byte(key), buf)
+}
+
+func init() {
+	backend.Register("boltdb", func(machines []string, options *backend.Config) (backend.Store, error) {
+		if len(machines) == 0 || machines[0] == "" {
+			return nil, errors.New("boltdb: a database file path is required")
+		}
+		bucket := defaultBucket
+		if options != nil && options.Bucket != "" {
+			bucket = options.Bucket
+		}
+		return New(machines[0], bucket)
+	})
+}
+
+// Client is a backend.Store backed by a single-node BoltDB file, for
+// offline/single-node use where there is no KV cluster to talk to.
+type Client struct {
+	db     *bolt.DB
+	bucket string
+	logger backend.Logger
+}
+
+// New opens (creating if necessary) the BoltDB file at path and
+// ensures bucket exists. An empty bucket falls back to "crypt".
+func New(path string, bucket string) (*Client, error) {
+	if bucket == "" {
+		bucket = defaultBucket
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists([]byte(indexBucket(bucket)))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Client{db: db, bucket: bucket}, nil
+}
+
+func (c *Client) Get(key string) ([]byte, error) {
+	var value []byte
+	err := c.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(c.bucket)).Get([]byte(key))
+		if v == nil {
+			return fmt.Errorf("boltdb: key was not found error: %s not found.", key)
+		}
+		value = append([]byte(nil), v...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (c *Client) List(key string) (backend.KVPairs, error) {
+	var list backend.KVPairs
+	dir := path.Clean(key) + "/"
+	err := c.db.View(func(tx *bolt.Tx) error {
+		idx := tx.Bucket([]byte(indexBucket(c.bucket)))
+		return tx.Bucket([]byte(c.bucket)).ForEach(func(k, v []byte) error {
+			if strings.HasPrefix(string(k), dir) {
+				list = append(list, &backend.KVPair{Key: string(k), Value: append([]byte(nil), v...), LastIndex: getIndex(idx, string(k))})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (c *Client) Set(key string, value []byte) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(c.bucket))
+		if err := b.Put([]byte(key), value); err != nil {
+			return err
+		}
+		idx := tx.Bucket([]byte(indexBucket(c.bucket)))
+		seq, err := idx.NextSequence()
+		if err != nil {
+			return err
+		}
+		return putIndex(idx, key, seq)
+	})
+}
+
+func (c *Client) Delete(key string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte(c.bucket)).Delete([]byte(key)); err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(indexBucket(c.bucket))).Delete([]byte(key))
+	})
+}
+
+// Watch has no native change notification in BoltDB, so it polls the
+// key at watchPollInterval and only emits a response when the value
+// changes.
+func (c *Client) Watch(key string, stop chan bool) <-chan *backend.Response {
+	respChan := make(chan *backend.Response, 0)
+	go func() {
+		defer close(respChan)
+		var last []byte
+		seen := false
+		ticker := time.NewTicker(watchPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				value, err := c.Get(key)
+				if err != nil {
+					if !backend.KeyNotFound(err) {
+						select {
+						case respChan <- &backend.Response{Error: err}:
+						case <-stop:
+							return
+						}
+					}
+					continue
+				}
+				if !seen || !bytes.Equal(value, last) {
+					seen = true
+					last = value
+					select {
+					case respChan <- &backend.Response{Value: value}:
+					case <-stop:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return respChan
+}
+
+func (c *Client) SetLogger(l backend.Logger) {
+	c.logger = l
+}
+
+func (c *Client) NewLock(key string, options *backend.LockOptions) (backend.Locker, error) {
+	return nil, backend.ErrNotImplemented
+}
+
+// AtomicPut writes value to key only if key's LastIndex equals
+// previous.LastIndex (or key does not yet exist, when previous is
+// nil). The compare is index-based rather than content-based, so it
+// also works for values that are re-encrypted (and therefore change on
+// every write).
+func (c *Client) AtomicPut(key string, value []byte, previous *backend.KVPair, options *backend.WriteOptions) (*backend.KVPair, error) {
+	var result *backend.KVPair
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(c.bucket))
+		idx := tx.Bucket([]byte(indexBucket(c.bucket)))
+		current := b.Get([]byte(key))
+		if previous == nil {
+			if current != nil {
+				return fmt.Errorf("boltdb: AtomicPut %s error: key already exists", key)
+			}
+		} else if current == nil || getIndex(idx, key) != previous.LastIndex {
+			return fmt.Errorf("boltdb: AtomicPut %s error: index mismatch", key)
+		}
+		if err := b.Put([]byte(key), value); err != nil {
+			return err
+		}
+		seq, err := idx.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := putIndex(idx, key, seq); err != nil {
+			return err
+		}
+		result = &backend.KVPair{Key: key, Value: value, LastIndex: seq}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AtomicDelete removes key only if its current LastIndex matches
+// previous.LastIndex.
+func (c *Client) AtomicDelete(key string, previous *backend.KVPair) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(c.bucket))
+		idx := tx.Bucket([]byte(indexBucket(c.bucket)))
+		current := b.Get([]byte(key))
+		if current == nil {
+			return nil
+		}
+		if previous == nil || getIndex(idx, key) != previous.LastIndex {
+			return fmt.Errorf("boltdb: AtomicDelete %s error: index mismatch", key)
+		}
+		if err := b.Delete([]byte(key)); err != nil {
+			return err
+		}
+		return idx.Delete([]byte(key))
+	})
+}
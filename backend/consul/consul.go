@@ -0,0 +1,223 @@
+// Package consul implements backend.Store against consul's KV store,
+// using ModifyIndex-based compare-and-swap for AtomicPut/AtomicDelete
+// and a session-backed distributed lock for NewLock.
+package consul
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/ltick/crypt/backend"
+)
+
+func init() {
+	backend.Register("consul", func(machines []string, options *backend.Config) (backend.Store, error) {
+		return NewWithConfig(machines, options)
+	})
+}
+
+// Client is a backend.Store backed by consul's KV store.
+type Client struct {
+	client *api.Client
+	logger backend.Logger
+}
+
+// New connects to machines with no TLS/auth.
+func New(machines []string) (*Client, error) {
+	return NewWithConfig(machines, nil)
+}
+
+// NewWithConfig connects to machines using cfg's TLS/mTLS and
+// ACL-token options.
+func NewWithConfig(machines []string, cfg *backend.Config) (*Client, error) {
+	apiCfg := api.DefaultConfig()
+	if len(machines) > 0 {
+		apiCfg.Address = machines[0]
+	}
+	if cfg != nil {
+		apiCfg.Token = cfg.Token
+		if cfg.Username != "" {
+			apiCfg.HttpAuth = &api.HttpBasicAuth{Username: cfg.Username, Password: cfg.Password}
+		}
+		if len(cfg.CACert) > 0 || len(cfg.Cert) > 0 || cfg.InsecureSkipVerify {
+			apiCfg.TLSConfig = api.TLSConfig{
+				CAPem:              cfg.CACert,
+				CertPEM:            cfg.Cert,
+				KeyPEM:             cfg.Key,
+				InsecureSkipVerify: cfg.InsecureSkipVerify,
+			}
+		}
+	}
+	c, err := api.NewClient(apiCfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{client: c}, nil
+}
+
+func (c *Client) Get(key string) ([]byte, error) {
+	pair, _, err := c.client.KV().Get(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if pair == nil {
+		return nil, fmt.Errorf("consul: key was not found error: %s not found.", key)
+	}
+	return pair.Value, nil
+}
+
+func (c *Client) List(key string) (backend.KVPairs, error) {
+	pairs, _, err := c.client.KV().List(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	dir := path.Clean(key) + "/"
+	var list backend.KVPairs
+	for _, pair := range pairs {
+		if pair.Key == key || !strings.HasPrefix(pair.Key, dir) {
+			continue
+		}
+		list = append(list, &backend.KVPair{Key: pair.Key, Value: pair.Value, LastIndex: pair.ModifyIndex})
+	}
+	return list, nil
+}
+
+func (c *Client) Set(key string, value []byte) error {
+	_, err := c.client.KV().Put(&api.KVPair{Key: key, Value: value}, nil)
+	return err
+}
+
+func (c *Client) Delete(key string) error {
+	_, err := c.client.KV().Delete(key, nil)
+	return err
+}
+
+// Watch long-polls key via consul's blocking queries until stop is
+// closed.
+func (c *Client) Watch(key string, stop chan bool) <-chan *backend.Response {
+	respChan := make(chan *backend.Response, 0)
+	go func() {
+		defer close(respChan)
+		var lastIndex uint64
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			pair, meta, err := c.client.KV().Get(key, &api.QueryOptions{WaitIndex: lastIndex, WaitTime: 30 * time.Second})
+			if err != nil {
+				select {
+				case respChan <- &backend.Response{Error: err}:
+				case <-stop:
+					return
+				}
+				continue
+			}
+			if meta != nil {
+				lastIndex = meta.LastIndex
+			}
+			if pair == nil {
+				continue
+			}
+			select {
+			case respChan <- &backend.Response{Value: pair.Value}:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return respChan
+}
+
+func (c *Client) SetLogger(l backend.Logger) {
+	c.logger = l
+}
+
+// NewLock returns a Locker backed by a consul session, so a holder
+// that dies is detected through session expiry/invalidation.
+func (c *Client) NewLock(key string, options *backend.LockOptions) (backend.Locker, error) {
+	lockOpts := &api.LockOptions{Key: key}
+	if options != nil && options.Value != nil {
+		lockOpts.Value = options.Value
+	}
+	ttl := 15 * time.Second
+	if options != nil && options.TTL > 0 {
+		ttl = options.TTL
+	}
+	sessionEntry := &api.SessionEntry{TTL: ttl.String(), Behavior: api.SessionBehaviorRelease}
+	session, _, err := c.client.Session().Create(sessionEntry, nil)
+	if err != nil {
+		return nil, err
+	}
+	lockOpts.Session = session
+	lock, err := c.client.LockOpts(lockOpts)
+	if err != nil {
+		return nil, err
+	}
+	return &locker{client: c.client, session: session, lock: lock}, nil
+}
+
+type locker struct {
+	client  *api.Client
+	session string
+	lock    *api.Lock
+}
+
+func (l *locker) Lock(stop chan struct{}) (<-chan struct{}, error) {
+	lost, err := l.lock.Lock(stop)
+	if err == nil && lost == nil {
+		// api.Lock reports a closed stop as (nil, nil).
+		return nil, fmt.Errorf("consul: lock cancelled")
+	}
+	return lost, err
+}
+
+func (l *locker) Unlock() error {
+	if err := l.lock.Unlock(); err != nil {
+		return err
+	}
+	_, err := l.client.Session().Destroy(l.session, nil)
+	return err
+}
+
+// AtomicPut writes value to key only if the key's ModifyIndex equals
+// previous.LastIndex (or key does not yet exist, when previous is
+// nil), via consul's native check-and-set KV write.
+func (c *Client) AtomicPut(key string, value []byte, previous *backend.KVPair, options *backend.WriteOptions) (*backend.KVPair, error) {
+	pair := &api.KVPair{Key: key, Value: value}
+	if previous != nil {
+		pair.ModifyIndex = previous.LastIndex
+	}
+	ok, _, err := c.client.KV().CAS(pair, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("consul: AtomicPut %s error: index mismatch", key)
+	}
+	current, _, err := c.client.KV().Get(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &backend.KVPair{Key: key, Value: value, LastIndex: current.ModifyIndex}, nil
+}
+
+// AtomicDelete removes key only if its current ModifyIndex matches
+// previous.LastIndex, via consul's native check-and-set KV delete.
+func (c *Client) AtomicDelete(key string, previous *backend.KVPair) error {
+	if previous == nil {
+		return fmt.Errorf("consul: AtomicDelete %s error: previous is required", key)
+	}
+	ok, _, err := c.client.KV().DeleteCAS(&api.KVPair{Key: key, ModifyIndex: previous.LastIndex}, nil)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("consul: AtomicDelete %s error: index mismatch", key)
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+// Package etcd implements backend.Store against etcd v3, using
+// revision-based compare-and-swap for AtomicPut/AtomicDelete and a
+// lease-backed session for NewLock.
+package etcd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/ltick/crypt/backend"
+	mvccpb "go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+func init() {
+	backend.Register("etcd", func(machines []string, options *backend.Config) (backend.Store, error) {
+		return NewWithConfig(machines, options)
+	})
+}
+
+// Client is a backend.Store backed by etcd v3.
+type Client struct {
+	client *clientv3.Client
+	logger backend.Logger
+}
+
+// New connects to machines with no TLS/auth.
+func New(machines []string) (*Client, error) {
+	return NewWithConfig(machines, nil)
+}
+
+// NewWithConfig connects to machines using cfg's TLS/mTLS, basic-auth
+// and connect-timeout options.
+func NewWithConfig(machines []string, cfg *backend.Config) (*Client, error) {
+	etcdCfg := clientv3.Config{
+		Endpoints:   machines,
+		DialTimeout: 5 * time.Second,
+	}
+	if cfg != nil {
+		etcdCfg.Username = cfg.Username
+		etcdCfg.Password = cfg.Password
+		if cfg.ConnectTimeout > 0 {
+			etcdCfg.DialTimeout = cfg.ConnectTimeout
+		}
+		if len(cfg.CACert) > 0 || len(cfg.Cert) > 0 || cfg.InsecureSkipVerify {
+			tlsConfig, err := newTLSConfig(cfg)
+			if err != nil {
+				return nil, err
+			}
+			etcdCfg.TLS = tlsConfig
+		}
+	}
+	c, err := clientv3.New(etcdCfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{client: c}, nil
+}
+
+func newTLSConfig(cfg *backend.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if len(cfg.Cert) > 0 && len(cfg.Key) > 0 {
+		cert, err := tls.X509KeyPair(cfg.Cert, cfg.Key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if len(cfg.CACert) > 0 {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(cfg.CACert)
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+func (c *Client) Get(key string) ([]byte, error) {
+	resp, err := c.client.Get(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, fmt.Errorf("etcd: key was not found error: %s not found.", key)
+	}
+	return resp.Kvs[0].Value, nil
+}
+
+func (c *Client) List(key string) (backend.KVPairs, error) {
+	resp, err := c.client.Get(context.Background(), path.Clean(key)+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	list := make(backend.KVPairs, len(resp.Kvs))
+	for i, kv := range resp.Kvs {
+		list[i] = &backend.KVPair{Key: string(kv.Key), Value: kv.Value, LastIndex: uint64(kv.ModRevision)}
+	}
+	return list, nil
+}
+
+func (c *Client) Set(key string, value []byte) error {
+	_, err := c.client.Put(context.Background(), key, string(value))
+	return err
+}
+
+func (c *Client) Delete(key string) error {
+	_, err := c.client.Delete(context.Background(), key)
+	return err
+}
+
+// Watch emits key's current value, if any, then streams every change
+// to it until stop is closed. clientv3.Watch only streams future
+// events, so without this initial Get a subscriber sees nothing until
+// the next write.
+func (c *Client) Watch(key string, stop chan bool) <-chan *backend.Response {
+	respChan := make(chan *backend.Response, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	watchChan := c.client.Watch(ctx, key)
+
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	go func() {
+		defer close(respChan)
+		if value, err := c.Get(key); err == nil {
+			select {
+			case respChan <- &backend.Response{Value: value}:
+			case <-stop:
+				return
+			}
+		} else if !backend.KeyNotFound(err) {
+			select {
+			case respChan <- &backend.Response{Error: err}:
+			case <-stop:
+				return
+			}
+		}
+		for wresp := range watchChan {
+			if err := wresp.Err(); err != nil {
+				select {
+				case respChan <- &backend.Response{Error: err}:
+				case <-stop:
+					return
+				}
+				continue
+			}
+			for _, ev := range wresp.Events {
+				if ev.Type == mvccpb.DELETE {
+					select {
+					case respChan <- &backend.Response{Error: fmt.Errorf("etcd: key was not found error: %s not found.", key)}:
+					case <-stop:
+						return
+					}
+					continue
+				}
+				select {
+				case respChan <- &backend.Response{Value: ev.Kv.Value}:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+	return respChan
+}
+
+func (c *Client) SetLogger(l backend.Logger) {
+	c.logger = l
+}
+
+// NewLock returns a Locker that elects a single holder for key via an
+// etcd lease-backed session (concurrency.Session), so a holder that
+// dies or partitions away is detected through lease expiry, much like
+// zookeeper's ephemeral nodes.
+func (c *Client) NewLock(key string, options *backend.LockOptions) (backend.Locker, error) {
+	ttl := 20
+	if options != nil && options.TTL > 0 {
+		if t := int(options.TTL / time.Second); t > 0 {
+			ttl = t
+		}
+	}
+	session, err := concurrency.NewSession(c.client, concurrency.WithTTL(ttl))
+	if err != nil {
+		return nil, err
+	}
+	return &locker{session: session, mutex: concurrency.NewMutex(session, key)}, nil
+}
+
+type locker struct {
+	session *concurrency.Session
+	mutex   *concurrency.Mutex
+}
+
+func (l *locker) Lock(stop chan struct{}) (<-chan struct{}, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-done:
+		}
+	}()
+	err := l.mutex.Lock(ctx)
+	close(done)
+	if err != nil {
+		return nil, err
+	}
+	return l.session.Done(), nil
+}
+
+func (l *locker) Unlock() error {
+	if err := l.mutex.Unlock(context.Background()); err != nil {
+		return err
+	}
+	return l.session.Close()
+}
+
+// AtomicPut writes value to key only if the key's ModRevision equals
+// previous.LastIndex (0, i.e. absent, when previous is nil), checked
+// and written inside a single Txn so the two stay atomic.
+func (c *Client) AtomicPut(key string, value []byte, previous *backend.KVPair, options *backend.WriteOptions) (*backend.KVPair, error) {
+	var want int64
+	if previous != nil {
+		want = int64(previous.LastIndex)
+	}
+	cmp := clientv3.Compare(clientv3.ModRevision(key), "=", want)
+	resp, err := c.client.Txn(context.Background()).
+		If(cmp).
+		Then(clientv3.OpPut(key, string(value))).
+		Commit()
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Succeeded {
+		return nil, fmt.Errorf("etcd: AtomicPut %s error: index mismatch", key)
+	}
+	getResp, err := c.client.Get(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+	return &backend.KVPair{Key: key, Value: value, LastIndex: uint64(getResp.Kvs[0].ModRevision)}, nil
+}
+
+// AtomicDelete removes key only if its current ModRevision matches
+// previous.LastIndex.
+func (c *Client) AtomicDelete(key string, previous *backend.KVPair) error {
+	if previous == nil {
+		return fmt.Errorf("etcd: AtomicDelete %s error: previous is required", key)
+	}
+	cmp := clientv3.Compare(clientv3.ModRevision(key), "=", int64(previous.LastIndex))
+	resp, err := c.client.Txn(context.Background()).
+		If(cmp).
+		Then(clientv3.OpDelete(key)).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return fmt.Errorf("etcd: AtomicDelete %s error: index mismatch", key)
+	}
+	return nil
+}
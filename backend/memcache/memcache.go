@@ -0,0 +1,167 @@
+// Package memcache implements backend.Store against memcache. Locking
+// is not supported (memcache has no session/lease primitive to build
+// one on), so NewLock returns backend.ErrNotImplemented.
+package memcache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/ltick/crypt/backend"
+)
+
+const watchPollInterval = 5 * time.Second
+
+func init() {
+	backend.Register("memcache", func(machines []string, options *backend.Config) (backend.Store, error) {
+		return New(machines)
+	})
+}
+
+// Client is a backend.Store backed by memcache.
+type Client struct {
+	client *memcache.Client
+	logger backend.Logger
+}
+
+func New(machines []string) (*Client, error) {
+	return &Client{client: memcache.New(machines...)}, nil
+}
+
+func (c *Client) Get(key string) ([]byte, error) {
+	item, err := c.client.Get(key)
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+			return nil, fmt.Errorf("memcache: key was not found error: %s not found.", key)
+		}
+		return nil, err
+	}
+	return item.Value, nil
+}
+
+// List is not supported: memcache has no notion of key hierarchy.
+func (c *Client) List(key string) (backend.KVPairs, error) {
+	return nil, errors.New("memcache: List is not supported")
+}
+
+func (c *Client) Set(key string, value []byte) error {
+	return c.client.Set(&memcache.Item{Key: key, Value: value})
+}
+
+func (c *Client) Delete(key string) error {
+	err := c.client.Delete(key)
+	if err == memcache.ErrCacheMiss {
+		return nil
+	}
+	return err
+}
+
+// Watch polls Get at watchPollInterval, since memcache has no change
+// notification, and only emits a response when the value changes.
+func (c *Client) Watch(key string, stop chan bool) <-chan *backend.Response {
+	respChan := make(chan *backend.Response, 0)
+	go func() {
+		defer close(respChan)
+		var last []byte
+		seen := false
+		ticker := time.NewTicker(watchPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				value, err := c.Get(key)
+				if err != nil {
+					if backend.KeyNotFound(err) {
+						continue
+					}
+					select {
+					case respChan <- &backend.Response{Error: err}:
+					case <-stop:
+						return
+					}
+					continue
+				}
+				if !seen || !bytes.Equal(value, last) {
+					seen = true
+					last = value
+					select {
+					case respChan <- &backend.Response{Value: value}:
+					case <-stop:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return respChan
+}
+
+func (c *Client) SetLogger(l backend.Logger) {
+	c.logger = l
+}
+
+func (c *Client) NewLock(key string, options *backend.LockOptions) (backend.Locker, error) {
+	return nil, backend.ErrNotImplemented
+}
+
+// AtomicPut writes value to key, conditioned on the item's CAS token
+// (or create-only, via Add, when previous is nil). gomemcache does not
+// expose the CAS token it tracks internally, so the returned KVPair's
+// LastIndex is left at 0; callers on this backend cannot chain a
+// further AtomicPut/AtomicDelete off of it.
+func (c *Client) AtomicPut(key string, value []byte, previous *backend.KVPair, options *backend.WriteOptions) (*backend.KVPair, error) {
+	if previous == nil {
+		if err := c.client.Add(&memcache.Item{Key: key, Value: value}); err != nil {
+			if err == memcache.ErrNotStored {
+				return nil, fmt.Errorf("memcache: AtomicPut %s error: key already exists", key)
+			}
+			return nil, err
+		}
+		return &backend.KVPair{Key: key, Value: value}, nil
+	}
+	item, err := c.client.Get(key)
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+			return nil, fmt.Errorf("memcache: AtomicPut %s error: key was not found", key)
+		}
+		return nil, err
+	}
+	item.Value = value
+	if err := c.client.CompareAndSwap(item); err != nil {
+		if err == memcache.ErrCASConflict || err == memcache.ErrNotStored {
+			return nil, fmt.Errorf("memcache: AtomicPut %s error: index mismatch", key)
+		}
+		return nil, err
+	}
+	return &backend.KVPair{Key: key, Value: value}, nil
+}
+
+// AtomicDelete removes key, conditioned on the item's CAS token having
+// not changed since it was read here. previous.LastIndex is ignored
+// (see AtomicPut); memcache has no atomic "delete if CAS token
+// matches", so this approximates one by compare-and-swapping the item
+// back onto itself to detect a concurrent writer, then deleting.
+func (c *Client) AtomicDelete(key string, previous *backend.KVPair) error {
+	if previous == nil {
+		return fmt.Errorf("memcache: AtomicDelete %s error: previous is required", key)
+	}
+	item, err := c.client.Get(key)
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+			return nil
+		}
+		return err
+	}
+	if err := c.client.CompareAndSwap(item); err != nil {
+		if err == memcache.ErrCASConflict || err == memcache.ErrNotStored {
+			return fmt.Errorf("memcache: AtomicDelete %s error: index mismatch", key)
+		}
+		return err
+	}
+	return c.client.Delete(key)
+}
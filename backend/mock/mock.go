@@ -1,73 +1,199 @@
 package mock
 
 import (
-	"errors"
+	"fmt"
 	"path"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/ltick/crypt/backend"
-	"fmt"
 )
 
-var mockedStore map[string][]byte
+func init() {
+	backend.Register("mock", func(machines []string, options *backend.Config) (backend.Store, error) {
+		return New(machines)
+	})
+}
+
+// subscription is one Watch registration: ch is the channel handed
+// back to the caller, and stop is the same channel the caller passed
+// to Watch, so a pending send can be abandoned the moment the caller
+// stops watching instead of blocking forever.
+type subscription struct {
+	ch   chan *backend.Response
+	stop chan bool
+}
 
-type Client struct{
+// store holds every key/value pair mock.Client sees, plus the
+// subscriptions registered via Watch, guarded by a single mutex so
+// Set/Delete can fan out changes without racing readers.
+type store struct {
+	mu          sync.Mutex
+	data        map[string][]byte
+	index       map[string]uint64
+	counter     uint64
+	subscribers map[string][]*subscription
+}
+
+// notifyLocked returns the subscriptions watching key, either directly
+// or via a directory prefix (a Watch registered on a key ending in "/"
+// is treated as watching everything under it). Callers must hold mu.
+func (s *store) notifyLocked(key string) []*subscription {
+	var subs []*subscription
+	subs = append(subs, s.subscribers[key]...)
+	for watched, subbed := range s.subscribers {
+		if watched != key && strings.HasSuffix(watched, "/") && strings.HasPrefix(key, watched) {
+			subs = append(subs, subbed...)
+		}
+	}
+	return subs
+}
+
+// notify delivers resp to every subscription in subs, abandoning a
+// send the instant its subscriber's stop fires instead of blocking on
+// a channel nobody is guaranteed to still be draining.
+func notify(subs []*subscription, resp *backend.Response) {
+	for _, sub := range subs {
+		select {
+		case sub.ch <- resp:
+		case <-sub.stop:
+		}
+	}
+}
+
+var mockedStore *store
+
+type Client struct {
 	logger backend.Logger
 }
 
 func New(machines []string) (*Client, error) {
 	if mockedStore == nil {
-		mockedStore = make(map[string][]byte, 2)
+		mockedStore = &store{
+			data:        make(map[string][]byte, 2),
+			index:       make(map[string]uint64, 2),
+			subscribers: make(map[string][]*subscription),
+		}
 	}
 	return &Client{}, nil
 }
 
 func (c *Client) Get(key string) ([]byte, error) {
-	if v, ok := mockedStore[key]; ok {
+	mockedStore.mu.Lock()
+	defer mockedStore.mu.Unlock()
+	if v, ok := mockedStore.data[key]; ok {
 		return v, nil
 	}
-	err := fmt.Errorf("mock: key was not found error: %s not found.", key)
-	return nil, err
+	return nil, fmt.Errorf("mock: key was not found error: %s not found.", key)
 }
 
 func (c *Client) List(key string) (backend.KVPairs, error) {
+	mockedStore.mu.Lock()
+	defer mockedStore.mu.Unlock()
 	var list backend.KVPairs
 	dir := path.Clean(key) + "/"
-	for k, v := range mockedStore {
+	for k, v := range mockedStore.data {
 		if strings.HasPrefix(k, dir) {
-			list = append(list, &backend.KVPair{Key: k, Value: v})
+			list = append(list, &backend.KVPair{Key: k, Value: v, LastIndex: mockedStore.index[k]})
 		}
 	}
 	return list, nil
 }
 
 func (c *Client) Set(key string, value []byte) error {
-	mockedStore[key] = value
+	mockedStore.mu.Lock()
+	mockedStore.counter++
+	mockedStore.data[key] = value
+	mockedStore.index[key] = mockedStore.counter
+	subs := mockedStore.notifyLocked(key)
+	mockedStore.mu.Unlock()
+
+	notify(subs, &backend.Response{Value: value})
 	return nil
 }
 
 func (c *Client) Delete(key string) error {
-	delete(mockedStore, key)
+	mockedStore.mu.Lock()
+	delete(mockedStore.data, key)
+	delete(mockedStore.index, key)
+	subs := mockedStore.notifyLocked(key)
+	mockedStore.mu.Unlock()
+
+	notify(subs, &backend.Response{Error: fmt.Errorf("mock: key was not found error: %s not found.", key)})
 	return nil
 }
 
+// Watch registers key as a subscriber and fans out one *backend.Response
+// per Set/Delete on key (or, for a key ending in "/", per Set/Delete
+// anywhere under it) until stop is closed.
 func (c *Client) Watch(key string, stop chan bool) <-chan *backend.Response {
-	respChan := make(chan *backend.Response, 0)
+	sub := &subscription{ch: make(chan *backend.Response, 1), stop: stop}
+	mockedStore.mu.Lock()
+	mockedStore.subscribers[key] = append(mockedStore.subscribers[key], sub)
+	mockedStore.mu.Unlock()
+
 	go func() {
-		for {
-			b, err := c.Get(key)
-			if err != nil {
-				respChan <- &backend.Response{nil, err}
-				time.Sleep(time.Second * 5)
-				continue
+		<-stop
+		mockedStore.mu.Lock()
+		defer mockedStore.mu.Unlock()
+		subs := mockedStore.subscribers[key]
+		for i, s := range subs {
+			if s == sub {
+				mockedStore.subscribers[key] = append(subs[:i], subs[i+1:]...)
+				break
 			}
-			respChan <- &backend.Response{b, nil}
 		}
 	}()
-	return respChan
+	return sub.ch
 }
 
 func (c *Client) SetLogger(l backend.Logger) {
 	c.logger = l
-}
\ No newline at end of file
+}
+
+func (c *Client) NewLock(key string, options *backend.LockOptions) (backend.Locker, error) {
+	return nil, backend.ErrNotImplemented
+}
+
+func (c *Client) AtomicPut(key string, value []byte, previous *backend.KVPair, options *backend.WriteOptions) (*backend.KVPair, error) {
+	mockedStore.mu.Lock()
+	_, ok := mockedStore.data[key]
+	if previous == nil {
+		if ok {
+			mockedStore.mu.Unlock()
+			return nil, fmt.Errorf("mock: AtomicPut %s error: key already exists", key)
+		}
+	} else if !ok || mockedStore.index[key] != previous.LastIndex {
+		mockedStore.mu.Unlock()
+		return nil, fmt.Errorf("mock: AtomicPut %s error: index mismatch", key)
+	}
+	mockedStore.counter++
+	mockedStore.data[key] = value
+	mockedStore.index[key] = mockedStore.counter
+	result := &backend.KVPair{Key: key, Value: value, LastIndex: mockedStore.counter}
+	subs := mockedStore.notifyLocked(key)
+	mockedStore.mu.Unlock()
+
+	notify(subs, &backend.Response{Value: value})
+	return result, nil
+}
+
+func (c *Client) AtomicDelete(key string, previous *backend.KVPair) error {
+	mockedStore.mu.Lock()
+	_, ok := mockedStore.data[key]
+	if !ok {
+		mockedStore.mu.Unlock()
+		return fmt.Errorf("mock: AtomicDelete %s error: key was not found", key)
+	}
+	if previous == nil || mockedStore.index[key] != previous.LastIndex {
+		mockedStore.mu.Unlock()
+		return fmt.Errorf("mock: AtomicDelete %s error: index mismatch", key)
+	}
+	delete(mockedStore.data, key)
+	delete(mockedStore.index, key)
+	subs := mockedStore.notifyLocked(key)
+	mockedStore.mu.Unlock()
+
+	notify(subs, &backend.Response{Error: fmt.Errorf("mock: key was not found error: %s not found.", key)})
+	return nil
+}
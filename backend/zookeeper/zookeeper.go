@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ltick/crypt/backend"
@@ -13,33 +14,63 @@ import (
 
 var connectTimeout time.Duration = 120 * time.Second
 
+func init() {
+	backend.Register("zookeeper", func(machines []string, options *backend.Config) (backend.Store, error) {
+		return NewWithConfig(machines, options)
+	})
+}
+
 type Client struct {
 	client   *zk.Conn
 	user     string
 	password string
 	errors   chan error
 	logger   backend.Logger
+
+	sessionMu   sync.Mutex
+	sessionLost chan struct{}
 }
 
 var client *Client
 
 func New(machines []string, user string, password string) (*Client, error) {
+	return newClient(machines, user, password, connectTimeout)
+}
+
+// NewWithConfig connects using cfg's credentials and connect timeout.
+// zookeeper's wire protocol has no notion of TLS, so cfg's TLS fields
+// are ignored. Only cfg.Username/cfg.Password are sent, as digest
+// auth; cfg.Token is not used.
+func NewWithConfig(machines []string, cfg *backend.Config) (*Client, error) {
+	var user, password string
+	timeout := connectTimeout
+	if cfg != nil {
+		user, password = cfg.Username, cfg.Password
+		if cfg.ConnectTimeout > 0 {
+			timeout = cfg.ConnectTimeout
+		}
+	}
+	return newClient(machines, user, password, timeout)
+}
+
+func newClient(machines []string, user string, password string, timeout time.Duration) (*Client, error) {
 	if client != nil {
 		return client, nil
 	}
 	for index, machine := range machines {
 		machines[index] = strings.TrimSpace(machine)
 	}
-	zkClient, _, err := zk.Connect(machines, connectTimeout)
+	zkClient, _, err := zk.Connect(machines, timeout)
 	if err != nil {
 		return nil, err
 	}
 	client = &Client{
-		client:   zkClient,
-		user:     user,
-		password: password,
-		errors:   make(chan error, 1),
-		logger:   nil,
+		client:      zkClient,
+		user:        user,
+		password:    password,
+		errors:      make(chan error, 1),
+		logger:      nil,
+		sessionLost: make(chan struct{}),
 	}
 	if err = client.addAuth(); err != nil {
 		return nil, err
@@ -50,6 +81,7 @@ func New(machines []string, user string, password string) (*Client, error) {
 			case err := <-client.errors:
 				if err == zk.ErrSessionExpired {
 					client.addAuth()
+					client.notifySessionLost()
 				} else {
 					//log
 				}
@@ -59,6 +91,22 @@ func New(machines []string, user string, password string) (*Client, error) {
 	return client, nil
 }
 
+// notifySessionLost closes the current session-lost channel, waking
+// any Locker waiting on it, and swaps in a fresh one for the next
+// Lock call.
+func (c *Client) notifySessionLost() {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+	close(c.sessionLost)
+	c.sessionLost = make(chan struct{})
+}
+
+func (c *Client) currentSessionLost() chan struct{} {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+	return c.sessionLost
+}
+
 func (c *Client) Get(key string) ([]byte, error) {
 	value, _, err := c.client.Get(key)
 	if err != nil {
@@ -130,32 +178,44 @@ func (c *Client) Watch(key string, stop chan bool) <-chan *backend.Response {
 
 func (c *Client) WatchWithContext(ctx context.Context, key string, stop chan bool) <-chan *backend.Response {
 	respChan := make(chan *backend.Response, 0)
+	ctx, cancel := context.WithCancel(ctx)
 	go func() {
+		defer close(respChan)
+		defer cancel()
+
 		value, _, event, err := c.client.GetW(key)
 		if err != nil {
 			respChan <- &backend.Response{nil, err}
 		} else {
 			respChan <- &backend.Response{value, nil}
 		}
-		_, cancel := context.WithCancel(ctx)
 		for {
 			select {
 			case <-stop:
-				c.client.Close()
-				cancel()
-				break
+				return
+			case <-ctx.Done():
+				return
 			case e := <-event:
 				if e.Err != nil {
 					respChan <- &backend.Response{nil, e.Err}
+					continue
 				}
 				switch e.Type {
-				case zk.EventNodeDataChanged:
+				case zk.EventNodeDataChanged, zk.EventNodeCreated:
 					value, _, event, err = c.client.GetW(key)
 					if err != nil {
 						respChan <- &backend.Response{nil, err}
 					} else {
 						respChan <- &backend.Response{value, nil}
 					}
+				case zk.EventNodeDeleted:
+					respChan <- &backend.Response{nil, fmt.Errorf("zookeeper: key was not found error: %s.", key)}
+					// re-arm the watch on the (now absent) node so a
+					// later Create is still observed.
+					_, _, event, err = c.client.ExistsW(key)
+					if err != nil {
+						respChan <- &backend.Response{nil, err}
+					}
 				}
 			}
 		}
@@ -176,3 +236,97 @@ func (c *Client) addAuth() error {
 	}
 	return nil
 }
+
+// NewLock returns a Locker that elects a single holder for key using
+// zookeeper's ephemeral-sequential-node protocol (zk.NewLock).
+func (c *Client) NewLock(key string, options *backend.LockOptions) (backend.Locker, error) {
+	return &zkLocker{
+		client: c,
+		key:    key,
+		lock:   zk.NewLock(c.client, key, zk.WorldACL(zk.PermAll)),
+	}, nil
+}
+
+type zkLocker struct {
+	client *Client
+	key    string
+	lock   *zk.Lock
+	lost   chan struct{}
+}
+
+// Lock blocks until the lock is acquired or stop is closed.
+func (l *zkLocker) Lock(stop chan struct{}) (<-chan struct{}, error) {
+	l.lost = l.client.currentSessionLost()
+	acquired := make(chan error, 1)
+	go func() { acquired <- l.lock.Lock() }()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			return nil, err
+		}
+		return l.lost, nil
+	case <-stop:
+		return nil, errors.New("zookeeper: lock on " + l.key + " cancelled")
+	}
+}
+
+func (l *zkLocker) Unlock() error {
+	return l.lock.Unlock()
+}
+
+// AtomicPut writes value to key only if the key's stat.Version equals
+// previous.LastIndex (or key does not yet exist, when previous is
+// nil), conditioning the actual write on stat.Version so the check and
+// the write stay atomic even under concurrent writers. The compare is
+// index-based rather than content-based, so it also works for values
+// that are re-encrypted (and therefore change on every write).
+func (c *Client) AtomicPut(key string, value []byte, previous *backend.KVPair, options *backend.WriteOptions) (*backend.KVPair, error) {
+	_, stat, err := c.client.Get(key)
+	if err != nil {
+		if err != zk.ErrNoNode {
+			c.errors <- err
+			return nil, errors.New("zookeeper: AtomicPut " + key + " error: " + err.Error())
+		}
+		if previous != nil {
+			return nil, errors.New("zookeeper: AtomicPut " + key + " error: key does not exist")
+		}
+		if _, err = c.client.Create(key, value, 0, zk.WorldACL(zk.PermAll)); err != nil {
+			return nil, errors.New("zookeeper: AtomicPut " + key + " error: " + err.Error())
+		}
+		_, stat, err = c.client.Get(key)
+		if err != nil {
+			return nil, errors.New("zookeeper: AtomicPut " + key + " error: " + err.Error())
+		}
+		return &backend.KVPair{Key: key, Value: value, LastIndex: uint64(stat.Version)}, nil
+	}
+	if previous == nil || uint64(stat.Version) != previous.LastIndex {
+		return nil, errors.New("zookeeper: AtomicPut " + key + " error: index mismatch")
+	}
+	newStat, err := c.client.Set(key, value, stat.Version)
+	if err != nil {
+		c.errors <- err
+		return nil, errors.New("zookeeper: AtomicPut " + key + " error: " + err.Error())
+	}
+	return &backend.KVPair{Key: key, Value: value, LastIndex: uint64(newStat.Version)}, nil
+}
+
+// AtomicDelete removes key only if its current stat.Version matches
+// previous.LastIndex.
+func (c *Client) AtomicDelete(key string, previous *backend.KVPair) error {
+	_, stat, err := c.client.Get(key)
+	if err != nil {
+		if err == zk.ErrNoNode {
+			return nil
+		}
+		c.errors <- err
+		return errors.New("zookeeper: AtomicDelete " + key + " error: " + err.Error())
+	}
+	if previous == nil || uint64(stat.Version) != previous.LastIndex {
+		return errors.New("zookeeper: AtomicDelete " + key + " error: index mismatch")
+	}
+	if err = c.client.Delete(key, stat.Version); err != nil {
+		c.errors <- err
+		return errors.New("zookeeper: AtomicDelete " + key + " error: " + err.Error())
+	}
+	return nil
+}
@@ -1,15 +1,17 @@
 package config
 
 import (
-	"bytes"
 	"io"
 	"io/ioutil"
 
 	"github.com/ltick/crypt/backend"
-	"github.com/ltick/crypt/backend/consul"
-	"github.com/ltick/crypt/backend/etcd"
-	"github.com/ltick/crypt/backend/memcache"
-	"github.com/ltick/crypt/backend/zookeeper"
+	_ "github.com/ltick/crypt/backend/boltdb"
+	_ "github.com/ltick/crypt/backend/consul"
+	_ "github.com/ltick/crypt/backend/etcd"
+	_ "github.com/ltick/crypt/backend/memcache"
+	_ "github.com/ltick/crypt/backend/mock"
+	_ "github.com/ltick/crypt/backend/zookeeper"
+	"github.com/ltick/crypt/encoding"
 	"github.com/ltick/crypt/encoding/secconf"
 )
 
@@ -20,8 +22,8 @@ type KVPair struct {
 type KVPairs []KVPair
 
 type configManager struct {
-	keystore []byte
-	store    backend.Store
+	codec encoding.Codec
+	store backend.Store
 }
 
 // A ConfigManager retrieves and decrypts configuration from a key/value store.
@@ -32,6 +34,9 @@ type ConfigManager interface {
 	Watch(key string, stop chan bool) <-chan *Response
 	Delete(key string) error
 	SetLogger(l backend.Logger)
+	NewLock(key string, options *backend.LockOptions) (backend.Locker, error)
+	AtomicPut(key string, value []byte, previous *backend.KVPair, options *backend.WriteOptions) (*backend.KVPair, error)
+	AtomicDelete(key string, previous *backend.KVPair) error
 }
 
 type standardConfigManager struct {
@@ -43,16 +48,30 @@ func NewStandardConfigManager(client backend.Store) (ConfigManager, error) {
 }
 
 func NewConfigManager(client backend.Store, keystore io.Reader) (ConfigManager, error) {
-	bytes, err := ioutil.ReadAll(keystore)
+	keystoreBytes, err := ioutil.ReadAll(keystore)
 	if err != nil {
 		return nil, err
 	}
-	return configManager{bytes, client}, nil
+	return NewConfigManagerWithCodec(client, secconf.Codec{Keystore: keystoreBytes})
+}
+
+// NewConfigManagerWithCodec returns a new ConfigManager that encrypts
+// and decrypts values through codec, for key-management stories other
+// than an OpenPGP keystore (age, a cloud KMS, Vault Transit, ...).
+func NewConfigManagerWithCodec(client backend.Store, codec encoding.Codec) (ConfigManager, error) {
+	return configManager{codec, client}, nil
+}
+
+// NewStore creates a Store for the named, registered backend (e.g.
+// "etcd", "consul", "zookeeper", "memcache", "mock", or any backend a
+// third party has linked in and registered under its own name).
+func NewStore(name string, machines []string, options *backend.Config) (backend.Store, error) {
+	return backend.NewStore(name, machines, options)
 }
 
 // NewStandardEtcdConfigManager returns a new ConfigManager backed by etcd.
 func NewStandardEtcdConfigManager(machines []string) (ConfigManager, error) {
-	store, err := etcd.New(machines)
+	store, err := NewStore("etcd", machines, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -62,7 +81,7 @@ func NewStandardEtcdConfigManager(machines []string) (ConfigManager, error) {
 
 // NewStandardConsulConfigManager returns a new ConfigManager backed by consul.
 func NewStandardConsulConfigManager(machines []string) (ConfigManager, error) {
-	store, err := consul.New(machines)
+	store, err := NewStore("consul", machines, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -72,7 +91,7 @@ func NewStandardConsulConfigManager(machines []string) (ConfigManager, error) {
 // NewStandardMemcacheConfigManager returns a new ConfigManager backed by memcache.
 // Data will be encrypted.
 func NewStandardMemcacheConfigManager(machines []string) (ConfigManager, error) {
-	store, err := memcache.New(machines)
+	store, err := NewStore("memcache", machines, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -82,7 +101,7 @@ func NewStandardMemcacheConfigManager(machines []string) (ConfigManager, error)
 // NewStandardZookeeperConfigManager returns a new ConfigManager backed by zookeeper.
 // Data will be encrypted.
 func NewStandardZookeeperConfigManager(machines []string, user string, password string) (ConfigManager, error) {
-	store, err := zookeeper.New(machines, user, password)
+	store, err := NewStore("zookeeper", machines, &backend.Config{Username: user, Password: password})
 	if err != nil {
 		return nil, err
 	}
@@ -92,7 +111,7 @@ func NewStandardZookeeperConfigManager(machines []string, user string, password
 // NewEtcdConfigManager returns a new ConfigManager backed by etcd.
 // Data will be encrypted.
 func NewEtcdConfigManager(machines []string, keystore io.Reader) (ConfigManager, error) {
-	store, err := etcd.New(machines)
+	store, err := NewStore("etcd", machines, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -102,7 +121,7 @@ func NewEtcdConfigManager(machines []string, keystore io.Reader) (ConfigManager,
 // NewConsulConfigManager returns a new ConfigManager backed by consul.
 // Data will be encrypted.
 func NewConsulConfigManager(machines []string, keystore io.Reader) (ConfigManager, error) {
-	store, err := consul.New(machines)
+	store, err := NewStore("consul", machines, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -112,7 +131,7 @@ func NewConsulConfigManager(machines []string, keystore io.Reader) (ConfigManage
 // NewMemcacheConfigManager returns a new ConfigManager backed by memcache.
 // Data will be encrypted.
 func NewMemcacheConfigManager(machines []string, keystore io.Reader) (ConfigManager, error) {
-	store, err := memcache.New(machines)
+	store, err := NewStore("memcache", machines, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -122,20 +141,69 @@ func NewMemcacheConfigManager(machines []string, keystore io.Reader) (ConfigMana
 // NewZookeeperConfigManager returns a new ConfigManager backed by zookeeper.
 // Data will be encrypted.
 func NewZookeeperConfigManager(machines []string, user string, password string, keystore io.Reader) (ConfigManager, error) {
-	store, err := zookeeper.New(machines, user, password)
+	store, err := NewStore("zookeeper", machines, &backend.Config{Username: user, Password: password})
+	if err != nil {
+		return nil, err
+	}
+	return NewConfigManager(store, keystore)
+}
+
+// NewEtcdConfigManagerWithConfig returns a new ConfigManager backed by
+// etcd, connecting with cfg's TLS/mTLS, basic-auth and connect-timeout
+// options. cfg.Token is not used by the etcd backend.
+// Data will be encrypted.
+func NewEtcdConfigManagerWithConfig(machines []string, cfg *backend.Config, keystore io.Reader) (ConfigManager, error) {
+	store, err := NewStore("etcd", machines, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewConfigManager(store, keystore)
+}
+
+// NewConsulConfigManagerWithConfig returns a new ConfigManager backed
+// by consul, connecting with cfg's TLS/mTLS and ACL-token options.
+// Data will be encrypted.
+func NewConsulConfigManagerWithConfig(machines []string, cfg *backend.Config, keystore io.Reader) (ConfigManager, error) {
+	store, err := NewStore("consul", machines, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewConfigManager(store, keystore)
+}
+
+// NewZookeeperConfigManagerWithConfig returns a new ConfigManager
+// backed by zookeeper, connecting with cfg's credentials and connect
+// timeout (zookeeper has no native TLS support, so cfg's TLS fields
+// are ignored).
+// Data will be encrypted.
+func NewZookeeperConfigManagerWithConfig(machines []string, cfg *backend.Config, keystore io.Reader) (ConfigManager, error) {
+	store, err := NewStore("zookeeper", machines, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewConfigManager(store, keystore)
+}
+
+// NewBoltDBConfigManager returns a new ConfigManager backed by a local
+// BoltDB file at path, storing keys in bucket (falls back to a
+// default bucket when empty). Data will be encrypted. This lets
+// ltick/crypt be used without a network KV cluster, and unlike mock
+// the data survives process restarts.
+func NewBoltDBConfigManager(path string, bucket string, keystore io.Reader) (ConfigManager, error) {
+	store, err := NewStore("boltdb", []string{path}, &backend.Config{Bucket: bucket})
 	if err != nil {
 		return nil, err
 	}
 	return NewConfigManager(store, keystore)
 }
 
-// Get retrieves and decodes a secconf value stored at key.
+// Get retrieves and decodes a value stored at key.
 func (c configManager) Get(key string) ([]byte, error) {
 	value, err := c.store.Get(key)
 	if err != nil {
 		return nil, err
 	}
-	return secconf.Decode(value, bytes.NewBuffer(c.keystore))
+	return c.codec.Decode(value)
 }
 
 // Get retrieves a value stored at key.
@@ -149,7 +217,7 @@ func (c standardConfigManager) Get(key string) ([]byte, error) {
 	return value, err
 }
 
-// List retrieves and decodes all secconf value stored under key.
+// List retrieves and decodes all values stored under key.
 func (c configManager) List(key string) (KVPairs, error) {
 	list, err := c.store.List(key)
 	retList := make(KVPairs, len(list))
@@ -158,7 +226,7 @@ func (c configManager) List(key string) (KVPairs, error) {
 	}
 	for i, kv := range list {
 		retList[i].Key = kv.Key
-		retList[i].Value, err = secconf.Decode(kv.Value, bytes.NewBuffer(c.keystore))
+		retList[i].Value, err = c.codec.Decode(kv.Value)
 		if err != nil {
 			return nil, err
 		}
@@ -182,10 +250,9 @@ func (c standardConfigManager) List(key string) (KVPairs, error) {
 	return retList, err
 }
 
-// Set will put a key/value into the data store
-// and encode it with secconf
+// Set will put a key/value into the data store, encoded with codec
 func (c configManager) Set(key string, value []byte) error {
-	encodedValue, err := secconf.Encode(value, bytes.NewBuffer(c.keystore))
+	encodedValue, err := c.codec.Encode(value)
 	if err == nil {
 		err = c.store.Set(key, encodedValue)
 	}
@@ -218,6 +285,51 @@ func (c standardConfigManager) SetLogger(l backend.Logger) {
 	c.store.SetLogger(l)
 }
 
+// NewLock returns a Locker that coordinates mutual exclusion / leader
+// election on key, e.g. to elect a single writer for an encrypted
+// config rollout.
+func (c configManager) NewLock(key string, options *backend.LockOptions) (backend.Locker, error) {
+	return c.store.NewLock(key, options)
+}
+
+// NewLock returns a Locker that coordinates mutual exclusion / leader
+// election on key.
+func (c standardConfigManager) NewLock(key string, options *backend.LockOptions) (backend.Locker, error) {
+	return c.store.NewLock(key, options)
+}
+
+// AtomicPut encodes value and writes it to key only if the key's
+// LastIndex still matches previous.LastIndex, so secrets can be
+// rotated without lost updates across processes. The condition is
+// index-based rather than content-based: secconf, age, and KMS
+// encryption are all non-deterministic, so a freshly re-encoded
+// previous value could never match the ciphertext already stored.
+func (c configManager) AtomicPut(key string, value []byte, previous *backend.KVPair, options *backend.WriteOptions) (*backend.KVPair, error) {
+	encodedValue, err := c.codec.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	return c.store.AtomicPut(key, encodedValue, previous, options)
+}
+
+// AtomicPut writes value to key only if the key's LastIndex still
+// matches previous.LastIndex.
+func (c standardConfigManager) AtomicPut(key string, value []byte, previous *backend.KVPair, options *backend.WriteOptions) (*backend.KVPair, error) {
+	return c.store.AtomicPut(key, value, previous, options)
+}
+
+// AtomicDelete removes key only if its currently stored LastIndex
+// matches previous.LastIndex.
+func (c configManager) AtomicDelete(key string, previous *backend.KVPair) error {
+	return c.store.AtomicDelete(key, previous)
+}
+
+// AtomicDelete removes key only if its currently stored LastIndex
+// matches previous.LastIndex.
+func (c standardConfigManager) AtomicDelete(key string, previous *backend.KVPair) error {
+	return c.store.AtomicDelete(key, previous)
+}
+
 type Response struct {
 	Value []byte
 	Error error
@@ -236,7 +348,7 @@ func (c configManager) Watch(key string, stop chan bool) <-chan *Response {
 					resp <- &Response{nil, r.Error}
 					continue
 				}
-				value, err := secconf.Decode(r.Value, bytes.NewBuffer(c.keystore))
+				value, err := c.codec.Decode(r.Value)
 				resp <- &Response{value, err}
 			}
 		}
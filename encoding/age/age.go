@@ -0,0 +1,40 @@
+// Package age implements encoding.Codec using filippo.io/age, for
+// deployments that would rather manage recipient/identity key files
+// than an OpenPGP keystore.
+package age
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	"filippo.io/age"
+)
+
+// Codec encrypts to Recipients and decrypts with Identities.
+type Codec struct {
+	Recipients []age.Recipient
+	Identities []age.Identity
+}
+
+func (c Codec) Encode(plaintext []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, c.Recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c Codec) Decode(ciphertext []byte) ([]byte, error) {
+	r, err := age.Decrypt(bytes.NewReader(ciphertext), c.Identities...)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,11 @@
+// Package encoding defines the Codec interface ConfigManager uses to
+// encrypt and decrypt configuration values, so the key-management
+// story (an OpenPGP keystore, age, a cloud KMS, Vault Transit, ...)
+// is pluggable rather than hard-coded.
+package encoding
+
+// Codec encrypts and decrypts configuration values.
+type Codec interface {
+	Encode(plaintext []byte) ([]byte, error)
+	Decode(ciphertext []byte) ([]byte, error)
+}
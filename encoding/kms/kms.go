@@ -0,0 +1,96 @@
+// Package kms implements encoding.Codec against AWS KMS, so
+// ciphertext can only be produced/opened by principals that KMS
+// authorizes for the configured key.
+package kms
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// Codec envelope-encrypts values: Encode asks KMS to generate a fresh
+// AES-256 data key, encrypts the value locally with it via AES-GCM,
+// and stores the KMS-wrapped data key alongside the nonce and
+// ciphertext. KMS only ever sees the (small) data key, so values are
+// not bound by KMS's 4 KB Encrypt/Decrypt payload limit.
+type Codec struct {
+	KeyID  string
+	Client *kms.KMS
+}
+
+func (c Codec) Encode(plaintext []byte) ([]byte, error) {
+	dataKey, err := c.Client.GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:   aws.String(c.KeyID),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+	return packEnvelope(dataKey.CiphertextBlob, sealed), nil
+}
+
+func (c Codec) Decode(ciphertext []byte) ([]byte, error) {
+	encryptedDataKey, sealed, err := unpackEnvelope(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := c.Client.Decrypt(&kms.DecryptInput{CiphertextBlob: encryptedDataKey})
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("kms: ciphertext too short")
+	}
+	nonce, sealed := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// packEnvelope serializes the KMS-encrypted data key and the locally
+// sealed value as a length-prefixed data key followed by the sealed
+// value, so Decode can split them back apart without a delimiter.
+func packEnvelope(encryptedDataKey, sealed []byte) []byte {
+	buf := make([]byte, 2+len(encryptedDataKey)+len(sealed))
+	binary.BigEndian.PutUint16(buf, uint16(len(encryptedDataKey)))
+	copy(buf[2:], encryptedDataKey)
+	copy(buf[2+len(encryptedDataKey):], sealed)
+	return buf
+}
+
+func unpackEnvelope(envelope []byte) (encryptedDataKey, sealed []byte, err error) {
+	if len(envelope) < 2 {
+		return nil, nil, fmt.Errorf("kms: ciphertext too short")
+	}
+	keyLen := int(binary.BigEndian.Uint16(envelope))
+	if len(envelope) < 2+keyLen {
+		return nil, nil, fmt.Errorf("kms: ciphertext too short")
+	}
+	return envelope[2 : 2+keyLen], envelope[2+keyLen:], nil
+}
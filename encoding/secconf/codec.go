@@ -0,0 +1,17 @@
+package secconf
+
+import "bytes"
+
+// Codec adapts the package-level Encode/Decode helpers (OpenPGP via a
+// keystore) to the encoding.Codec interface.
+type Codec struct {
+	Keystore []byte
+}
+
+func (c Codec) Encode(plaintext []byte) ([]byte, error) {
+	return Encode(plaintext, bytes.NewReader(c.Keystore))
+}
+
+func (c Codec) Decode(ciphertext []byte) ([]byte, error) {
+	return Decode(ciphertext, bytes.NewReader(c.Keystore))
+}
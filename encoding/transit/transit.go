@@ -0,0 +1,78 @@
+// Package transit implements encoding.Codec against a Vault Transit
+// secrets engine mount, so the encryption key never leaves Vault.
+package transit
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Codec POSTs to /v1/transit/encrypt/:key and /v1/transit/decrypt/:key
+// on Address, authenticating with Token.
+type Codec struct {
+	Address string
+	Token   string
+	Key     string
+	Client  *http.Client
+}
+
+func (c Codec) httpClient() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+	return http.DefaultClient
+}
+
+func (c Codec) call(op string, body map[string]string) (map[string]string, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("%s/v1/transit/%s/%s", c.Address, op, c.Key)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", c.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("transit: %s returned %s", url, resp.Status)
+	}
+
+	var out struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return out.Data, nil
+}
+
+func (c Codec) Encode(plaintext []byte) ([]byte, error) {
+	data, err := c.call("encrypt", map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(plaintext),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data["ciphertext"]), nil
+}
+
+func (c Codec) Decode(ciphertext []byte) ([]byte, error) {
+	data, err := c.call("decrypt", map[string]string{
+		"ciphertext": string(ciphertext),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(data["plaintext"])
+}